@@ -3,25 +3,24 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
-
-	"github.com/dlclark/regexp2"
-	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
-	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	"syscall"
+	"time"
 )
 
 // these address should be changed accordingly when implemented in the hardware
 const (
 	// the mspID should be identical to the one used when calling cryptogen to generate credential files
-	// mspID = "Org1MSP"
+	mspID = "Org2MSP"
 	// the path of the certificates
 	cryptoPath  = "../fabric-samples-2.3/test-network/organizations/peerOrganizations/org2.example.com"
 	certPath    = cryptoPath + "/users/User1@org2.example.com/msp/signcerts/cert.pem"
@@ -36,32 +35,120 @@ const (
 	networkName  = "mychannel"
 	contractName = "basic"
 	userName     = "appUser"
+
+	defaultCheckpointPath = "checkpoint.json"
 )
 
 func main() {
+	listenFlag := flag.String("listen", string(listenChaincode), "event listen mode: chaincode, block, or filtered")
+	startBlockFlag := flag.Uint64("start-block", 0, "block number to seed the checkpoint with on first run (block/filtered modes only)")
+	checkpointFlag := flag.String("checkpoint", defaultCheckpointPath, "path to the checkpoint file used to resume block/filtered listening after a restart")
+	solverFlag := flag.String("solver", "admm", "consensus algorithm: admm (averaged ADMM) or gt (gradient tracking)")
+	etaFlag := flag.Float64("eta", 0, "ADMM step size applied to the local mismatch term (0 = use the 1/iter schedule)")
+	alphaFlag := flag.Float64("alpha", 0.1, "gradient-tracking primal step size")
+	pMinFlag := flag.Float64("pmin", 0, "lower bound for the shared primal variable P")
+	pMaxFlag := flag.Float64("pmax", 8, "upper bound for the shared primal variable P")
+	mismatchTolFlag := flag.Float64("mismatch-tol", 0.05, "terminate once |mismatch| falls below this")
+	lambdaTolFlag := flag.Float64("lambda-tol", 0.05, "terminate once the lambda step falls below this")
+	peersFlag := flag.String("peers", "", "comma-separated org/MSP IDs to wait for each round (default: a single unnamed peer)")
+	walletFlag := flag.String("wallet", "filesystem", "identity wallet backend: filesystem, inmemory, or remote-keyfetch (fetches key material over JSON-RPC; the key still lives in this process's memory, it is NOT remote signing)")
+	walletSignerURLFlag := flag.String("wallet-signer-url", os.Getenv("WALLET_SIGNER_URL"), "JSON-RPC URL of the key-fetch signer (wallet=remote-keyfetch only; defaults to $WALLET_SIGNER_URL)")
+	metricsPortFlag := flag.Int("metrics-port", 2112, "port to serve Prometheus metrics on at /metrics (0 disables the metrics server)")
+	noProgressTimeoutFlag := flag.Duration("no-progress-timeout", 60*time.Second, "how long to wait for a peer event before taking -no-progress-action")
+	noProgressActionFlag := flag.String("no-progress-action", "log", "what to do when -no-progress-timeout elapses: log, reregister, or exit")
+	submitMaxAttemptsFlag := flag.Int("submit-max-attempts", 5, "max SubmitTransaction attempts before giving up on MVCC_READ_CONFLICT/ENDORSEMENT_POLICY_FAILURE")
+	submitBaseDelayFlag := flag.Duration("submit-base-delay", 200*time.Millisecond, "initial backoff delay between SubmitTransaction retries")
+	submitMaxDelayFlag := flag.Duration("submit-max-delay", 5*time.Second, "cap on the backoff delay between SubmitTransaction retries")
+	flag.Parse()
+
+	if *metricsPortFlag != 0 {
+		startMetricsServer(*metricsPortFlag)
+	}
+
+	switch *noProgressActionFlag {
+	case "log", "reregister", "exit":
+	default:
+		log.Fatalf("Unknown -no-progress-action %q: must be log, reregister, or exit", *noProgressActionFlag)
+	}
+	retryPolicy := RetryPolicy{
+		MaxAttempts: *submitMaxAttemptsFlag,
+		BaseDelay:   *submitBaseDelayFlag,
+		MaxDelay:    *submitMaxDelayFlag,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Info().Str("signal", sig.String()).Msg("received shutdown signal")
+		cancel()
+	}()
+
+	mode := listenMode(*listenFlag)
+	switch mode {
+	case listenChaincode, listenBlock, listenFiltered:
+	default:
+		log.Fatalf("Unknown -listen mode %q: must be chaincode, block, or filtered", *listenFlag)
+	}
+
+	var solver ConsensusSolver
+	bounds := Bounds{Min: *pMinFlag, Max: *pMaxFlag}
+	tol := Tolerances{Mismatch: *mismatchTolFlag, LambdaStep: *lambdaTolFlag}
+	switch *solverFlag {
+	case "admm":
+		solver = AveragedADMMSolver{Eta: *etaFlag, Bounds: bounds, Tol: tol}
+	case "gt":
+		solver = GradientTrackingSolver{Alpha: *alphaFlag, Bounds: bounds, Tol: tol}
+	default:
+		log.Fatalf("Unknown -solver %q: must be admm or gt", *solverFlag)
+	}
+	expectedPeers := splitPeers(*peersFlag)
+	if len(expectedPeers) == 0 {
+		expectedPeers = []string{"peer"}
+	}
+
 	err := os.Setenv("DISCOVERY_AS_LOCALHOST", "true")
 	if err != nil {
 		log.Fatalf("Error setting DISCOVERY_AS_LOCALHOST environment variable: %v", err)
 		os.Exit(1)
 	}
 
+	credPath := filepath.Join(
+		"..",
+		"fabric-samples-2.3",
+		"test-network",
+		"organizations",
+		"peerOrganizations",
+		"org2.example.com",
+		"users",
+		"User1@org2.example.com",
+		"msp",
+	)
+
+	var walletBackend WalletBackend
+	switch *walletFlag {
+	case "filesystem":
+		walletBackend = FileSystemWalletBackend{Path: "wallet", CredPath: credPath}
+	case "inmemory":
+		walletBackend = InMemoryWalletBackend{CredPath: credPath}
+	case "remote-keyfetch":
+		if *walletSignerURLFlag == "" {
+			log.Fatal("-wallet=remote-keyfetch requires -wallet-signer-url or $WALLET_SIGNER_URL to be set")
+		}
+		walletBackend = RemoteKeyFetchWalletBackend{SignerURL: *walletSignerURLFlag, MspID: mspID}
+	default:
+		log.Fatalf("Unknown -wallet backend %q: must be filesystem, inmemory, or remote-keyfetch", *walletFlag)
+	}
+
 	log.Println("============ Creating wallet ============")
-	wallet, err := gateway.NewFileSystemWallet("wallet")
+	wallet, err := walletBackend.Wallet(userName)
 	if err != nil {
 		log.Fatalf("Failed to create wallet: %v", err)
 	}
 	log.Println("============ Wallet created ============")
 
-	if !wallet.Exists(userName) {
-		err = populateWallet(wallet, userName)
-		if err != nil {
-			log.Fatalf("->Failed to populate wallet contents: %v", err)
-		}
-		log.Printf("-> Successfully add user %s to wallet \n", userName)
-	} else {
-		log.Printf("->  User %s already exists", userName)
-	}
-
 	ccpPath := filepath.Join(
 		"..",
 		"fabric-samples-2.3",
@@ -73,21 +160,23 @@ func main() {
 	)
 
 	log.Println("============ connecting to gateway ============")
-	gw, err := gateway.Connect(
-		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
-		gateway.WithIdentity(wallet, userName),
-	)
+	gw, network, err := connectNetwork(wallet, ccpPath)
 	if err != nil {
 		log.Fatalf("Failed to connect to gateway: %v", err)
 	}
-	defer gw.Close()
+	cleanup := func() { gw.Close() }
+	defer func() { cleanup() }()
 	log.Println("============ Successfully connected to gateway ============")
+	log.Println("============ successfully connected to network", networkName, "============")
 
-	network, err := gw.GetNetwork("mychannel")
-	if err != nil {
-		log.Fatalf("Failed to get network: %v", err)
+	switch mode {
+	case listenBlock:
+		runBlockListener(ctx, wallet, ccpPath, gw, network, *checkpointFlag, *startBlockFlag)
+		return
+	case listenFiltered:
+		runFilteredBlockListener(ctx, wallet, ccpPath, gw, network, *checkpointFlag, *startBlockFlag)
+		return
 	}
-	log.Println("============ successfully connected to network", networkName, "============")
 
 	contract := network.GetContract(contractName)
 	log.Println("============ successfully got contract", contractName, "============")
@@ -98,56 +187,146 @@ func main() {
 		fmt.Printf("Failed to register contract event: %s", err)
 		return
 	}
-	defer contract.Unregister(reg)
+	cleanup = func() {
+		contract.Unregister(reg)
+		gw.Close()
+	}
 
 	var P float64 = 0
-	var l1 float64 = 2 * P
-	var m1 float64 = 1.5
+	var local PeerState = PeerState{Lambda: 2 * P, Mismatch: 1.5}
 	var iter int = 0
 	var terminate bool = false
+	roundPeers := make(map[string]PeerState, len(expectedPeers))
+	var runStarted time.Time
 iterLoop:
 	for {
 		select {
+		case <-ctx.Done():
+			logger.Info().Msg("shutdown requested, exiting consensus loop")
+			break iterLoop
+		case <-time.After(*noProgressTimeoutFlag):
+			switch *noProgressActionFlag {
+			case "log":
+				logger.Warn().
+					Dur("timeout", *noProgressTimeoutFlag).
+					Int("haveEvents", len(roundPeers)).
+					Int("wantEvents", len(expectedPeers)).
+					Msg("no peer event received before timeout")
+			case "reregister":
+				logger.Warn().Msg("re-registering chaincode event listener after no-progress timeout")
+				contract.Unregister(reg)
+				reg, notifier, err = contract.RegisterEvent(eventID)
+				if err != nil {
+					logger.Error().Err(err).Msg("failed to re-register chaincode event listener")
+					break iterLoop
+				}
+				cleanup = func() {
+					contract.Unregister(reg)
+					gw.Close()
+				}
+			case "exit":
+				logger.Error().Msg("exiting after no-progress timeout")
+				break iterLoop
+			}
 		case event := <-notifier:
-			fmt.Printf("Received CC event: %s - %s \n", event.EventName, event.Payload)
+			eventsReceivedTotal.WithLabelValues(event.EventName).Inc()
+			logger.Debug().Str("eventName", event.EventName).Str("payload", formatEventPayload(event.Payload)).Msg("received CC event")
+			payload, err := parsePayload(string(event.Payload))
+			if err != nil {
+				logger.Warn().Err(err).Str("eventName", event.EventName).Msg("skipping CC event")
+				continue
+			}
+			if runStarted.IsZero() {
+				runStarted = time.Now()
+			}
+			origin := payload.Origin
+			if origin == "" {
+				origin = expectedPeers[len(roundPeers)%len(expectedPeers)]
+			}
+			roundPeers[origin] = PeerState{Lambda: payload.Lambda, Mismatch: payload.Mismatch, Gradient: payload.Gradient}
+			if len(roundPeers) < len(expectedPeers) {
+				continue
+			}
+
 			iter += 1
-			l2 := getLambda(string(event.Payload))
-			m2 := getMismatch(string(event.Payload))
-			l1, m1, P, terminate = update(l1, l2, m1, m2, P, iter)
-			Lambda := fmt.Sprintf("%v", l1)
-			Mismatch := fmt.Sprintf("%v", m1)
-			_, err := contract.SubmitTransaction("SendUpdate", Lambda, Mismatch)
+			prevLambda := local.Lambda
+			local, P, terminate = solver.Step(local, P, roundPeers, iter)
+			roundPeers = make(map[string]PeerState, len(expectedPeers))
+			lambdaStep := local.Lambda - prevLambda
+			iterationsTotal.Inc()
+			mismatchAbs.Set(math.Abs(local.Mismatch))
+			lambdaStepAbs.Set(math.Abs(lambdaStep))
+			logger.Info().
+				Int("iter", iter).
+				Float64("lambda", local.Lambda).
+				Float64("mismatch", local.Mismatch).
+				Float64("P", P).
+				Bool("terminate", terminate).
+				Msg("consensus round complete")
+
+			Lambda := fmt.Sprintf("%v", local.Lambda)
+			Mismatch := fmt.Sprintf("%v", local.Mismatch)
+			Gradient := fmt.Sprintf("%v", local.Gradient)
+			txn, err := contract.CreateTransaction("SendUpdate")
+			if err != nil {
+				logger.Error().Err(err).Int("iter", iter).Msg("failed to create transaction, shutting down")
+				cancel()
+				break iterLoop
+			}
+			commit := txn.RegisterCommitEvent()
+			submitStart := time.Now()
+			_, err = submitWithRetry(retryPolicy, func() ([]byte, error) {
+				return txn.Submit(Lambda, Mismatch, Gradient)
+			})
+			submitTransactionSeconds.Observe(time.Since(submitStart).Seconds())
 			if err != nil {
-				panic(fmt.Errorf("failed to submit transaction: %w", err))
+				submitErrorsTotal.WithLabelValues(classifySubmitError(err)).Inc()
+				logger.Error().Err(err).Int("iter", iter).Msg("failed to submit transaction, shutting down")
+				cancel()
+				break iterLoop
+			}
+			txID := ""
+			select {
+			case status := <-commit:
+				if status != nil {
+					txID = status.TxID
+				}
+			case <-time.After(10 * time.Second):
+				logger.Warn().Int("iter", iter).Msg("timed out waiting for commit event")
 			}
+			logger.Info().Int("iter", iter).Str("txID", txID).Msg("submitted update transaction")
 			if terminate {
-				fmt.Printf("Done at iteration %v: P=%v, lambda=%v, mismatch=%v\n", iter, P, l1, m1)
+				timeToConvergenceSeconds.Observe(time.Since(runStarted).Seconds())
+				logger.Info().
+					Int("iter", iter).
+					Float64("P", P).
+					Float64("lambda", local.Lambda).
+					Float64("mismatch", local.Mismatch).
+					Msg("consensus run converged")
 				break iterLoop
 			}
 		}
 	}
 
-	contract.Unregister(reg)
+	if ctx.Err() != nil {
+		logger.Info().Msg("shutting down, skipping interactive prompts")
+		return
+	}
 
-	// funcLoop:
-	// 	for {
-	// 		fmt.Println("-> Continue?: [y/n] ")
-	// 		continueConfirm := catchOneInput()
-	// 		if isYes(continueConfirm) {
-	// 			invokeFunc(contract)
-	// 		} else if isNo(continueConfirm) {
-	// 			break funcLoop
-	// 		} else {
-	// 			fmt.Println("Wrong input")
-	// 		}
-	// 	}
+	fmt.Println("-> Invoke chaincode functions interactively? [y/n] ")
+	invokeConfirm := catchOneInput()
+	if isYes(invokeConfirm) {
+		if err := runInvokeREPL(contract); err != nil {
+			log.Printf("Invoke REPL exited: %v", err)
+		}
+	}
 
 	// eventReplayLoop:
 	// 	for {
 	// 		select {
 	// 		case event := <-notifier:
 	// 			fmt.Printf("Received CC event: %s - %s \n", event.EventName, event.Payload)
-	// 			if getLambda(string(event.Payload)) == 1.3456 {
+	// 			if payload, err := parsePayload(string(event.Payload)); err == nil && payload.Lambda == 1.3456 {
 	// 				break eventReplayLoop
 	// 			}
 	// 			// case <-time.After(1 * time.Second):
@@ -166,111 +345,6 @@ iterLoop:
 
 }
 
-func update(l1 float64, l2 float64, m1 float64, m2 float64, P float64, iter int) (float64, float64, float64, bool) {
-	var eta float64 = 1 / float64(iter)
-	if eta < 0.05 {
-		eta = 0.05
-	}
-	ltemp := 0.5*l1 + 0.5*l2 + eta*m1
-	Ptemp := ltemp / 2
-	if Ptemp > 8 {
-		Ptemp = 8
-	} else if Ptemp < 0 {
-		Ptemp = 0
-	}
-	mtemp := 0.5*m1 + 0.5*m2 + P - Ptemp
-
-	var terminate bool
-	if math.Abs(mtemp) < 0.05 && math.Abs(ltemp-l1) < 0.05 {
-		terminate = true
-	} else {
-		terminate = false
-	}
-
-	fmt.Printf("Iteration %v: Lambda=%v, Mismatch=%v, P=%v, Terminate=%v\n", iter, ltemp, mtemp, Ptemp, terminate)
-
-	return ltemp, mtemp, Ptemp, terminate
-}
-
-func getLambda(s string) float64 {
-
-	pattern := "(?<=Lambda=)[0-9.-]+(?=,)"
-
-	reg, err := regexp2.Compile(pattern, 0)
-	if err != nil {
-		fmt.Printf("reg: %v, err: %v\n", reg, err)
-		return 0
-	}
-
-	value, _ := reg.FindStringMatch(s)
-
-	Lambda, errLambda := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
-	if errLambda != nil {
-		log.Panic("Error capturing lambda")
-	}
-	return Lambda
-}
-
-func getMismatch(s string) float64 {
-
-	pattern := "(?<=Mismatch=)[0-9.-]+(?=, end)"
-
-	reg, err := regexp2.Compile(pattern, 0)
-	if err != nil {
-		fmt.Printf("reg: %v, err: %v\n", reg, err)
-		return 0
-	}
-
-	value, _ := reg.FindStringMatch(s)
-
-	Mismatch, errMismatch := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
-	if errMismatch != nil {
-		log.Panic("Error capturing mismatch")
-	}
-
-	return Mismatch
-}
-
-func populateWallet(wallet *gateway.Wallet, userName string) error {
-	credPath := filepath.Join(
-		"..",
-		"fabric-samples-2.3",
-		"test-network",
-		"organizations",
-		"peerOrganizations",
-		"org2.example.com",
-		"users",
-		"User1@org2.example.com",
-		"msp",
-	)
-
-	certPath := filepath.Join(credPath, "signcerts", "User1@org2.example.com-cert.pem")
-	// read the certificate pem
-	cert, err := ioutil.ReadFile(filepath.Clean(certPath))
-	if err != nil {
-		return err
-	}
-
-	keyDir := filepath.Join(credPath, "keystore")
-	// there's a single file in this dir containing the private key
-	files, err := ioutil.ReadDir(keyDir)
-	if err != nil {
-		return err
-	}
-	if len(files) != 1 {
-		return fmt.Errorf("keystore folder should have contain one file")
-	}
-	keyPath := filepath.Join(keyDir, files[0].Name())
-	key, err := ioutil.ReadFile(filepath.Clean(keyPath))
-	if err != nil {
-		return err
-	}
-
-	identity := gateway.NewX509Identity("Org2MSP", string(cert), string(key))
-
-	return wallet.Put(userName, identity)
-}
-
 func cleanUp() {
 	log.Println("-> Cleaning up wallet...")
 	if _, err := os.Stat("wallet"); err == nil {
@@ -288,33 +362,6 @@ func cleanUp() {
 	log.Println("-> Wallet cleaned up successfully")
 }
 
-func invokeFunc(contract *gateway.Contract) {
-	var functionName string
-	var paraNumber int
-	fmt.Println("-> Please enter the name of the smart contract function you want to invoke")
-	functionName = catchOneInput()
-	fmt.Println("-> Please enter the number of parameters")
-	paraNumber, _ = strconv.Atoi(catchOneInput())
-	var functionPara []string
-	for i := 0; i < paraNumber; i++ {
-		fmt.Printf("-> Please enter parameter %v: ", i+1)
-		functionPara = append(functionPara, catchOneInput())
-	}
-	if paraNumber == 0 {
-		result, err := contract.SubmitTransaction(functionName)
-		if err != nil {
-			panic(fmt.Errorf("failed to submit transaction: %w", err))
-		}
-		fmt.Printf("Result: %s \n", string(result))
-	} else {
-		result, err := contract.SubmitTransaction(functionName, functionPara...)
-		if err != nil {
-			panic(fmt.Errorf("failed to submit transaction: %w", err))
-		}
-		fmt.Printf("Result: %s \n", string(result))
-	}
-}
-
 func catchOneInput() string {
 	// instantiate a new reader
 	reader := bufio.NewReader(os.Stdin)
@@ -329,6 +376,19 @@ func catchOneInput() string {
 	return s
 }
 
+// splitPeers parses the comma-separated -peers flag into a slice of
+// trimmed, non-empty org/MSP IDs.
+func splitPeers(s string) []string {
+	var peers []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
 func isYes(s string) bool {
 	return strings.Compare(s, "Y") == 0 || strings.Compare(s, "y") == 0 || strings.Compare(s, "Yes") == 0 || strings.Compare(s, "yes") == 0
 }
@@ -354,3 +414,14 @@ func formatJSON(data []byte) string {
 	}
 	return result.String()
 }
+
+// formatEventPayload pretty-prints an event payload for logging when it
+// is JSON, and falls back to the raw string for payloads still using the
+// legacy regex-parsed format.
+func formatEventPayload(data []byte) string {
+	var js json.RawMessage
+	if json.Unmarshal(data, &js) != nil {
+		return string(data)
+	}
+	return formatJSON(data)
+}
@@ -0,0 +1,132 @@
+package main
+
+import "math"
+
+// PeerState is the state an agent contributes to a consensus round: its
+// local dual variable (Lambda), its constraint-mismatch residual, and,
+// for solvers that need it, a tracked gradient estimate.
+type PeerState struct {
+	Lambda   float64
+	Mismatch float64
+	Gradient float64
+}
+
+// Bounds clamps the shared primal variable P to a configurable operating
+// range, e.g. a physical power limit.
+type Bounds struct {
+	Min float64
+	Max float64
+}
+
+func (b Bounds) clamp(p float64) float64 {
+	if p < b.Min {
+		return b.Min
+	}
+	if p > b.Max {
+		return b.Max
+	}
+	return p
+}
+
+// Tolerances are the termination thresholds shared by every solver: a
+// round has converged once both the mismatch and the lambda step fall
+// below their tolerance.
+type Tolerances struct {
+	Mismatch   float64
+	LambdaStep float64
+}
+
+func (t Tolerances) converged(mismatch, lambdaStep float64) bool {
+	return math.Abs(mismatch) < t.Mismatch && math.Abs(lambdaStep) < t.LambdaStep
+}
+
+// ConsensusSolver advances one round of a distributed consensus
+// algorithm given this agent's local state, the shared primal variable
+// P, and the most recently received state of every peer, keyed by
+// org/MSP ID. It returns this agent's updated state, the updated P, and
+// whether the run has converged.
+type ConsensusSolver interface {
+	Step(local PeerState, P float64, peers map[string]PeerState, iter int) (next PeerState, nextP float64, terminate bool)
+}
+
+// AveragedADMMSolver is the original two-agent ADMM-style update
+// generalized to N peers: l_next = (1/(N+1)) * sum(l_i) + eta*m_local,
+// where the sum ranges over this agent and every peer. With a single
+// peer this reduces exactly to the original 0.5*l1 + 0.5*l2 + eta*m1
+// rule.
+type AveragedADMMSolver struct {
+	// Eta is the step size applied to the local mismatch term. If zero,
+	// it falls back to the original 1/iter schedule floored at 0.05.
+	Eta    float64
+	Bounds Bounds
+	Tol    Tolerances
+}
+
+func (s AveragedADMMSolver) Step(local PeerState, P float64, peers map[string]PeerState, iter int) (PeerState, float64, bool) {
+	eta := s.Eta
+	if eta <= 0 {
+		eta = 1 / float64(iter)
+		if eta < 0.05 {
+			eta = 0.05
+		}
+	}
+
+	n := float64(len(peers) + 1)
+	lambdaSum := local.Lambda
+	mismatchSum := local.Mismatch
+	for _, peer := range peers {
+		lambdaSum += peer.Lambda
+		mismatchSum += peer.Mismatch
+	}
+
+	lambdaNext := lambdaSum/n + eta*local.Mismatch
+	Pnext := s.Bounds.clamp(lambdaNext / 2)
+	mismatchNext := mismatchSum/n + P - Pnext
+
+	terminate := s.Tol.converged(mismatchNext, lambdaNext-local.Lambda)
+
+	return PeerState{Lambda: lambdaNext, Mismatch: mismatchNext}, Pnext, terminate
+}
+
+// GradientTrackingSolver implements a distributed gradient-tracking (GT)
+// consensus iteration. Every participating agent, including this one, is
+// weighted uniformly by a row-stochastic weight of 1/(N+1), which is the
+// natural weight matrix for a fully-connected set of orgs with no prior
+// on trust or link quality:
+//
+//	y_i+ = sum_j w_ij*y_j + (grad_i+ - grad_i)
+//	x_i+ = sum_j w_ij*x_j - alpha*y_i+
+//
+// grad_i is the local agent's estimate of the gradient of its share of
+// the consensus objective. This objective is defined, as in
+// AveragedADMMSolver, so that descending it drives the mismatch
+// residual to zero; that makes grad_i = -mismatch_i, the negative of the
+// local residual, and the primal step x_i+ = ... - alpha*y_i+ then
+// raises lambda when the mismatch is positive, the same negative
+// feedback the averaged-ADMM rule gets from its +eta*m_local term.
+type GradientTrackingSolver struct {
+	Alpha  float64
+	Bounds Bounds
+	Tol    Tolerances
+}
+
+func (s GradientTrackingSolver) Step(local PeerState, P float64, peers map[string]PeerState, iter int) (PeerState, float64, bool) {
+	w := 1 / float64(len(peers)+1)
+	grad := -local.Mismatch
+
+	ySum := w * local.Gradient
+	lambdaSum := w * local.Lambda
+	for _, peer := range peers {
+		ySum += w * peer.Gradient
+		lambdaSum += w * peer.Lambda
+	}
+	yNext := ySum + (grad - local.Gradient)
+
+	lambdaNext := lambdaSum - s.Alpha*yNext
+	Pnext := s.Bounds.clamp(lambdaNext / 2)
+	mismatchNext := local.Mismatch + P - Pnext
+
+	terminate := s.Tol.converged(mismatchNext, lambdaNext-local.Lambda)
+
+	return PeerState{Lambda: lambdaNext, Mismatch: mismatchNext, Gradient: yNext}, Pnext, terminate
+}
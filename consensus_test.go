@@ -0,0 +1,105 @@
+package main
+
+import "testing"
+
+// drive runs solver against a fixed-size ring of synthetic peers that all
+// start from the same initial state, simulating one event-per-peer
+// arriving each round, until it converges or maxIter is exceeded.
+func drive(t *testing.T, solver ConsensusSolver, peerCount int, maxIter int) int {
+	t.Helper()
+
+	local := PeerState{Lambda: 2, Mismatch: 1.5}
+	peerState := PeerState{Lambda: 2, Mismatch: 1.5}
+	var P float64
+
+	for iter := 1; iter <= maxIter; iter++ {
+		peers := make(map[string]PeerState, peerCount)
+		for i := 0; i < peerCount; i++ {
+			peers[string(rune('A'+i))] = peerState
+		}
+
+		next, nextP, terminate := solver.Step(local, P, peers, iter)
+		local, P = next, nextP
+		// Every synthetic peer mirrors this agent's own state so the
+		// whole ring converges symmetrically, matching how a real
+		// multi-org round drives every participant toward the same
+		// fixed point.
+		peerState = local
+
+		if terminate {
+			return iter
+		}
+	}
+
+	t.Fatalf("%T did not converge within %d iterations (P=%v, lambda=%v, mismatch=%v)", solver, maxIter, P, local.Lambda, local.Mismatch)
+	return -1
+}
+
+func TestAveragedADMMSolverConverges(t *testing.T) {
+	solver := AveragedADMMSolver{
+		Bounds: Bounds{Min: 0, Max: 8},
+		Tol:    Tolerances{Mismatch: 0.05, LambdaStep: 0.05},
+	}
+
+	for _, peerCount := range []int{1, 3} {
+		iters := drive(t, solver, peerCount, 200)
+		if iters <= 0 {
+			t.Errorf("peerCount=%d: expected a positive iteration count, got %d", peerCount, iters)
+		}
+	}
+}
+
+func TestGradientTrackingSolverConverges(t *testing.T) {
+	solver := GradientTrackingSolver{
+		Alpha:  0.3,
+		Bounds: Bounds{Min: 0, Max: 8},
+		Tol:    Tolerances{Mismatch: 0.05, LambdaStep: 0.05},
+	}
+
+	for _, peerCount := range []int{1, 3} {
+		iters := drive(t, solver, peerCount, 200)
+		if iters <= 0 {
+			t.Errorf("peerCount=%d: expected a positive iteration count, got %d", peerCount, iters)
+		}
+	}
+}
+
+// TestGradientTrackingSolverUsesPeerGradient guards against the wiring
+// regression where a peer's tracked gradient never made it onto the wire
+// (payload.go's UpdatePayload had no Gradient field), so every peer's
+// Gradient read as its zero value and Step's output didn't actually depend
+// on what peers reported.
+func TestGradientTrackingSolverUsesPeerGradient(t *testing.T) {
+	solver := GradientTrackingSolver{
+		Alpha:  0.3,
+		Bounds: Bounds{Min: 0, Max: 8},
+		Tol:    Tolerances{Mismatch: 0.05, LambdaStep: 0.05},
+	}
+	local := PeerState{Lambda: 2, Mismatch: 1.5}
+
+	zeroGradient := map[string]PeerState{"peerB": {Lambda: 2, Mismatch: 1.5, Gradient: 0}}
+	withGradient := map[string]PeerState{"peerB": {Lambda: 2, Mismatch: 1.5, Gradient: 5}}
+
+	next1, _, _ := solver.Step(local, 0, zeroGradient, 1)
+	next2, _, _ := solver.Step(local, 0, withGradient, 1)
+
+	if next1.Lambda == next2.Lambda {
+		t.Fatalf("peer.Gradient did not affect Step's output: got identical lambda %v for both", next1.Lambda)
+	}
+}
+
+func TestBoundsClamp(t *testing.T) {
+	b := Bounds{Min: 0, Max: 8}
+	cases := map[float64]float64{
+		-1: 0,
+		0:  0,
+		4:  4,
+		8:  8,
+		9:  8,
+	}
+	for in, want := range cases {
+		if got := b.clamp(in); got != want {
+			t.Errorf("clamp(%v) = %v, want %v", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// listenMode selects which kind of Fabric Gateway event this program
+// subscribes to.
+type listenMode string
+
+const (
+	listenChaincode listenMode = "chaincode"
+	listenBlock     listenMode = "block"
+	listenFiltered  listenMode = "filtered"
+)
+
+// reconnectDelay is how long to wait before re-registering after the
+// gateway connection drops.
+const reconnectDelay = 3 * time.Second
+
+// checkpointState is the on-disk record of the last block number this
+// process has finished handling, so a restart can resume from where it
+// left off instead of reprocessing (or missing) blocks.
+type checkpointState struct {
+	Have      bool   `json:"have"`
+	LastBlock uint64 `json:"lastBlock"`
+}
+
+// loadCheckpoint reads the checkpoint file at path. A missing file is not
+// an error: it just means there is nothing to resume from yet.
+func loadCheckpoint(path string) (checkpointState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return checkpointState{}, nil
+		}
+		return checkpointState{}, err
+	}
+	var cp checkpointState
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return checkpointState{}, fmt.Errorf("failed to parse checkpoint file %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint persists blockNum as the last processed block so the
+// next run can resume from it.
+func saveCheckpoint(path string, blockNum uint64) error {
+	data, err := json.Marshal(checkpointState{Have: true, LastBlock: blockNum})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// connectNetwork opens a fresh gateway connection and returns the named
+// network on it. It is used both for the initial connection and to
+// re-establish a network handle after a disconnect.
+func connectNetwork(wallet *gateway.Wallet, ccpPath string) (*gateway.Gateway, *gateway.Network, error) {
+	gw, err := gateway.Connect(
+		gateway.WithConfig(config.FromFile(filepath.Clean(ccpPath))),
+		gateway.WithIdentity(wallet, userName),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	network, err := gw.GetNetwork(networkName)
+	if err != nil {
+		gw.Close()
+		return nil, nil, err
+	}
+	return gw, network, nil
+}
+
+// runBlockListener subscribes to block events and logs each block as it
+// arrives, checkpointing progress after every block so the listener can
+// resume without reprocessing blocks it has already seen. It returns once
+// ctx is done, after unregistering and closing the current connection.
+//
+// Note: the gateway SDK's RegisterBlockEvent does not expose a way to seek
+// to an arbitrary starting block - that capability only exists on the
+// lower-level event service, not on gateway.Network. startBlock therefore
+// only seeds the checkpoint on first run (it has no effect once a
+// checkpoint file exists); it cannot force the peer to replay blocks
+// produced before the listener first connected.
+func runBlockListener(ctx context.Context, wallet *gateway.Wallet, ccpPath string, gw *gateway.Gateway, network *gateway.Network, checkpointPath string, startBlock uint64) {
+	cp, err := loadCheckpoint(checkpointPath)
+	if err != nil {
+		log.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	if !cp.Have && startBlock > 0 {
+		cp = checkpointState{Have: true, LastBlock: startBlock - 1}
+	}
+
+	for {
+		reg, notifier, err := network.RegisterBlockEvent()
+		if err != nil {
+			log.Printf("Failed to register block event listener: %v", err)
+			gw, network, err = reconnect(wallet, ccpPath, gw)
+			if err != nil {
+				log.Fatalf("Failed to reconnect to gateway: %v", err)
+			}
+			continue
+		}
+
+		log.Printf("============ listening for block events from block %d ============", cp.LastBlock+1)
+	eventLoop:
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("shutdown requested, stopping block event listener")
+				network.Unregister(reg)
+				gw.Close()
+				return
+			case event, ok := <-notifier:
+				if !ok {
+					break eventLoop
+				}
+				blockNum := event.Block.GetHeader().GetNumber()
+				if cp.Have && blockNum <= cp.LastBlock {
+					continue
+				}
+				fmt.Printf("Received block event: block %d from %s\n", blockNum, event.SourceURL)
+				cp = checkpointState{Have: true, LastBlock: blockNum}
+				if err := saveCheckpoint(checkpointPath, blockNum); err != nil {
+					log.Printf("Failed to persist checkpoint: %v", err)
+				}
+			}
+		}
+
+		network.Unregister(reg)
+		log.Printf("Block event notifier closed, reconnecting from checkpoint block %d", cp.LastBlock)
+		gw, network, err = reconnect(wallet, ccpPath, gw)
+		if err != nil {
+			log.Fatalf("Failed to reconnect to gateway: %v", err)
+		}
+	}
+}
+
+// runFilteredBlockListener is the filtered-block counterpart of
+// runBlockListener; see its doc comment for the replay caveat and the
+// ctx-done shutdown behavior.
+func runFilteredBlockListener(ctx context.Context, wallet *gateway.Wallet, ccpPath string, gw *gateway.Gateway, network *gateway.Network, checkpointPath string, startBlock uint64) {
+	cp, err := loadCheckpoint(checkpointPath)
+	if err != nil {
+		log.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	if !cp.Have && startBlock > 0 {
+		cp = checkpointState{Have: true, LastBlock: startBlock - 1}
+	}
+
+	for {
+		reg, notifier, err := network.RegisterFilteredBlockEvent()
+		if err != nil {
+			log.Printf("Failed to register filtered block event listener: %v", err)
+			gw, network, err = reconnect(wallet, ccpPath, gw)
+			if err != nil {
+				log.Fatalf("Failed to reconnect to gateway: %v", err)
+			}
+			continue
+		}
+
+		log.Printf("============ listening for filtered block events from block %d ============", cp.LastBlock+1)
+	eventLoop:
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("shutdown requested, stopping filtered block event listener")
+				network.Unregister(reg)
+				gw.Close()
+				return
+			case event, ok := <-notifier:
+				if !ok {
+					break eventLoop
+				}
+				blockNum := event.FilteredBlock.GetNumber()
+				if cp.Have && blockNum <= cp.LastBlock {
+					continue
+				}
+				fmt.Printf("Received filtered block event: block %d, channel %s, %d transaction(s) from %s\n",
+					blockNum, event.FilteredBlock.GetChannelId(), len(event.FilteredBlock.GetFilteredTransactions()), event.SourceURL)
+				cp = checkpointState{Have: true, LastBlock: blockNum}
+				if err := saveCheckpoint(checkpointPath, blockNum); err != nil {
+					log.Printf("Failed to persist checkpoint: %v", err)
+				}
+			}
+		}
+
+		network.Unregister(reg)
+		log.Printf("Filtered block event notifier closed, reconnecting from checkpoint block %d", cp.LastBlock)
+		gw, network, err = reconnect(wallet, ccpPath, gw)
+		if err != nil {
+			log.Fatalf("Failed to reconnect to gateway: %v", err)
+		}
+	}
+}
+
+// reconnect closes the stale gateway (if any) and establishes a new one,
+// waiting reconnectDelay beforehand to avoid hammering the peer.
+func reconnect(wallet *gateway.Wallet, ccpPath string, stale *gateway.Gateway) (*gateway.Gateway, *gateway.Network, error) {
+	if stale != nil {
+		stale.Close()
+	}
+	time.Sleep(reconnectDelay)
+	return connectNetwork(wallet, ccpPath)
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// logger is the application's structured logger. Consensus-loop log
+// lines carry iter/lambda/mismatch/P/eventName/txID fields so operators
+// can grep or feed stdout into a log aggregator instead of eyeballing
+// plain-text output to tell whether a run is stuck.
+var logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "15:04:05"}).With().Timestamp().Logger()
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	eventsReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consensus_events_received_total",
+		Help: "Chaincode events received, labeled by event ID.",
+	}, []string{"event_id"})
+
+	iterationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consensus_iterations_total",
+		Help: "Consensus rounds completed.",
+	})
+
+	mismatchAbs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "consensus_mismatch_abs",
+		Help: "Absolute mismatch residual (|m|) after the most recent round.",
+	})
+
+	lambdaStepAbs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "consensus_lambda_step_abs",
+		Help: "Absolute lambda step (|delta lambda|) after the most recent round.",
+	})
+
+	submitTransactionSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "consensus_submit_transaction_seconds",
+		Help:    "Latency of SubmitTransaction calls.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	submitErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consensus_submit_errors_total",
+		Help: "SubmitTransaction errors, labeled by error type.",
+	}, []string{"type"})
+
+	timeToConvergenceSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "consensus_time_to_convergence_seconds",
+		Help:    "Wall-clock time from the first round of a run to its termination.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
+
+// startMetricsServer exposes the default Prometheus registry on /metrics
+// at port and returns immediately; it runs until the process exits. A
+// server failure is logged rather than fatal, since losing /metrics
+// should not take down the consensus loop it's observing.
+func startMetricsServer(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	addr := fmt.Sprintf(":%d", port)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error().Err(err).Str("addr", addr).Msg("metrics server stopped")
+		}
+	}()
+}
+
+// classifySubmitError buckets a SubmitTransaction error for the
+// submit_errors_total counter. MVCC_READ_CONFLICT and
+// ENDORSEMENT_POLICY_FAILURE are surfaced separately from other
+// validation failures because, unlike those, they are worth retrying.
+func classifySubmitError(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "MVCC_READ_CONFLICT"):
+		return "mvcc_read_conflict"
+	case strings.Contains(msg, "ENDORSEMENT_POLICY_FAILURE"):
+		return "endorsement_policy_failure"
+	default:
+		return "other"
+	}
+}
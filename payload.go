@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+// UpdatePayload is the structured form of the update events emitted by the
+// consensus chaincode on each round. Gradient carries a peer's tracked
+// gradient estimate (PeerState.Gradient); GradientTrackingSolver cannot
+// drive its y-consensus step without it.
+type UpdatePayload struct {
+	Lambda    float64   `json:"lambda"`
+	Mismatch  float64   `json:"mismatch"`
+	Gradient  float64   `json:"gradient"`
+	Origin    string    `json:"origin"`
+	Iteration int       `json:"iteration"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MalformedPayloadError is returned by parsePayload when an event's
+// payload is neither valid UpdatePayload JSON nor the legacy
+// "Lambda=..., Mismatch=..., end" text format, so the caller can skip the
+// offending event instead of aborting the whole run.
+type MalformedPayloadError struct {
+	Payload string
+	Cause   error
+}
+
+func (e *MalformedPayloadError) Error() string {
+	return fmt.Sprintf("malformed update payload %q: %v", e.Payload, e.Cause)
+}
+
+func (e *MalformedPayloadError) Unwrap() error {
+	return e.Cause
+}
+
+// parsePayload decodes an update event payload into an UpdatePayload. It
+// tries JSON first, since that is the format chaincode is now expected to
+// emit, and falls back to the legacy "Lambda=<f>, Mismatch=<f>, end" text
+// format so this app keeps working against chaincode that has not yet
+// been upgraded.
+func parsePayload(s string) (UpdatePayload, error) {
+	var payload UpdatePayload
+	if err := json.Unmarshal([]byte(s), &payload); err == nil {
+		return payload, nil
+	}
+
+	lambda, err := legacyExtract(s, "(?<=Lambda=)[0-9.-]+(?=,)")
+	if err != nil {
+		return UpdatePayload{}, &MalformedPayloadError{Payload: s, Cause: err}
+	}
+	mismatch, err := legacyExtract(s, "(?<=Mismatch=)[0-9.-]+(?=, end)")
+	if err != nil {
+		return UpdatePayload{}, &MalformedPayloadError{Payload: s, Cause: err}
+	}
+	return UpdatePayload{Lambda: lambda, Mismatch: mismatch}, nil
+}
+
+// legacyExtract pulls a single float out of s using the given regexp2
+// lookaround pattern, the same patterns the old getLambda/getMismatch
+// helpers used.
+func legacyExtract(s string, pattern string) (float64, error) {
+	reg, err := regexp2.Compile(pattern, 0)
+	if err != nil {
+		return 0, err
+	}
+	match, err := reg.FindStringMatch(s)
+	if err != nil {
+		return 0, err
+	}
+	if match == nil {
+		return 0, fmt.Errorf("pattern %q did not match payload", pattern)
+	}
+	value, err := strconv.ParseFloat(match.String(), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q as float: %w", match.String(), err)
+	}
+	return value, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// invokeHistoryFile is where the REPL keeps its readline history between
+// runs, so pressing the up arrow recalls prior invocations across
+// restarts of this program.
+const invokeHistoryFile = ".test-listen-invoke-history"
+
+// runInvokeREPL is an interactive command loop for invoking arbitrary
+// chaincode functions against contract. Each line has the form:
+//
+//	<query|submit> <function> [args...] [--transient k=v,k2=v2] [--peers org1,org2]
+//
+// Arguments may be typed to control how they are encoded before being
+// sent to the chaincode: int:5, float:3.14, bool:true, json:{"a":1}, and
+// bytes@path/to/file.bin read a file's raw bytes. An argument with no
+// recognized prefix is passed through as a plain string. Type "exit" (or
+// press Ctrl-D) to leave the REPL.
+func runInvokeREPL(contract *gateway.Contract) error {
+	completer := readline.NewPrefixCompleter()
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "invoke> ",
+		HistoryFile:     invokeHistoryFile,
+		AutoComplete:    completer,
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start REPL: %w", err)
+	}
+	defer rl.Close()
+
+	knownFuncs := make(map[string]bool)
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if isExit(line) {
+			return nil
+		}
+
+		if err := invokeOnce(contract, line); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+
+		if fn := strings.Fields(line); len(fn) >= 2 && !knownFuncs[fn[1]] {
+			knownFuncs[fn[1]] = true
+			completer.Children = append(completer.Children, readline.PcItem(fn[1]))
+		}
+	}
+}
+
+// invokeOnce parses and runs a single REPL line.
+func invokeOnce(contract *gateway.Contract, line string) error {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(tokens) < 2 {
+		return fmt.Errorf("usage: <query|submit> <function> [args...] [--transient k=v,...] [--peers p1,p2,...]")
+	}
+
+	mode := tokens[0]
+	if mode != "query" && mode != "submit" {
+		return fmt.Errorf("unknown mode %q: must be query or submit", mode)
+	}
+	fnName := tokens[1]
+
+	var args []string
+	var transient map[string][]byte
+	var peers []string
+	for i := 2; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "--transient":
+			i++
+			if i >= len(tokens) {
+				return fmt.Errorf("--transient requires a k=v,... value")
+			}
+			transient, err = parseTransient(tokens[i])
+			if err != nil {
+				return err
+			}
+		case "--peers":
+			i++
+			if i >= len(tokens) {
+				return fmt.Errorf("--peers requires a comma-separated value")
+			}
+			peers = splitPeers(tokens[i])
+		default:
+			arg, err := parseTypedArg(tokens[i])
+			if err != nil {
+				return err
+			}
+			args = append(args, arg)
+		}
+	}
+
+	var opts []gateway.TransactionOption
+	if transient != nil {
+		opts = append(opts, gateway.WithTransient(transient))
+	}
+	if len(peers) > 0 {
+		opts = append(opts, gateway.WithEndorsingPeers(peers...))
+	}
+
+	txn, err := contract.CreateTransaction(fnName, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create transaction: %w", err)
+	}
+
+	if mode == "query" {
+		result, err := txn.Evaluate(args...)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate transaction: %w", err)
+		}
+		fmt.Printf("Proposal response: %s\n", formatEventPayload(result))
+		return nil
+	}
+
+	commit := txn.RegisterCommitEvent()
+	result, err := txn.Submit(args...)
+	if err != nil {
+		return fmt.Errorf("failed to submit transaction: %w", err)
+	}
+	fmt.Printf("Proposal response: %s\n", formatEventPayload(result))
+
+	select {
+	case status := <-commit:
+		if status != nil {
+			fmt.Printf("Transaction ID: %s (committed in block %d)\n", status.TxID, status.BlockNumber)
+		}
+	case <-time.After(10 * time.Second):
+		fmt.Println("Timed out waiting for the commit event; transaction ID unavailable")
+	}
+	return nil
+}
+
+// parseTypedArg decodes a single REPL argument token into the string
+// form SubmitTransaction/Evaluate expects, honoring the int:/float:/
+// bool:/json: type prefixes and the bytes@file syntax. A token without a
+// recognized prefix is returned unchanged.
+func parseTypedArg(raw string) (string, error) {
+	if rest, ok := strings.CutPrefix(raw, "bytes@"); ok {
+		data, err := ioutil.ReadFile(filepath.Clean(rest))
+		if err != nil {
+			return "", fmt.Errorf("failed to read bytes argument from %s: %w", rest, err)
+		}
+		return string(data), nil
+	}
+
+	typ, rest, ok := strings.Cut(raw, ":")
+	if !ok {
+		return raw, nil
+	}
+	switch typ {
+	case "int":
+		n, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid int argument %q: %w", rest, err)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "float":
+		f, err := strconv.ParseFloat(rest, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid float argument %q: %w", rest, err)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case "bool":
+		b, err := strconv.ParseBool(rest)
+		if err != nil {
+			return "", fmt.Errorf("invalid bool argument %q: %w", rest, err)
+		}
+		return strconv.FormatBool(b), nil
+	case "json":
+		var js json.RawMessage
+		if err := json.Unmarshal([]byte(rest), &js); err != nil {
+			return "", fmt.Errorf("invalid json argument %q: %w", rest, err)
+		}
+		return string(js), nil
+	default:
+		return raw, nil
+	}
+}
+
+// parseTransient parses a "k1=v1,k2=v2" argument into the map
+// gateway.WithTransient expects.
+func parseTransient(s string) (map[string][]byte, error) {
+	data := make(map[string][]byte)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --transient entry %q: expected key=value", pair)
+		}
+		data[key] = []byte(value)
+	}
+	return data, nil
+}
+
+// tokenize splits a REPL line on whitespace, honoring single- and
+// double-quoted segments so that json: arguments containing spaces can
+// be quoted as one token.
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	inQuotes := false
+	var quoteChar byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuotes:
+			if c == quoteChar {
+				inQuotes = false
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			inQuotes = true
+			quoteChar = c
+		case c == ' ' || c == '\t':
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures the exponential-backoff retry wrapped around
+// SubmitTransaction: up to MaxAttempts total tries, with the delay between
+// attempts doubling from BaseDelay up to MaxDelay and jittered by +/-50% so
+// peers retrying the same conflict don't all wake up in lockstep.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// fatalSubmitError marks a SubmitTransaction error that classifySubmitError
+// judged non-retryable, so callers can tell a validation failure from a
+// submit that simply ran out of retries.
+type fatalSubmitError struct {
+	err error
+}
+
+func (e *fatalSubmitError) Error() string { return e.err.Error() }
+func (e *fatalSubmitError) Unwrap() error { return e.err }
+
+// submitWithRetry calls submit, retrying with exponential backoff and
+// jitter on MVCC_READ_CONFLICT/ENDORSEMENT_POLICY_FAILURE errors - the kind
+// that commonly clear up on the next attempt - and returning immediately,
+// wrapped in a *fatalSubmitError, on anything classifySubmitError judges a
+// validation failure instead.
+func submitWithRetry(policy RetryPolicy, submit func() ([]byte, error)) ([]byte, error) {
+	delay := policy.BaseDelay
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		result, err := submit()
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+
+		class := classifySubmitError(err)
+		if class != "mvcc_read_conflict" && class != "endorsement_policy_failure" {
+			return nil, &fatalSubmitError{err: err}
+		}
+		if attempt == policy.MaxAttempts {
+			break
+		}
+
+		logger.Warn().Err(err).Int("attempt", attempt).Str("type", class).Msg("retrying SubmitTransaction")
+		sleep := delay / 2
+		if delay > 0 {
+			sleep += time.Duration(rand.Int63n(int64(delay)))
+		}
+		time.Sleep(sleep)
+		if delay *= 2; delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+	return nil, fmt.Errorf("SubmitTransaction failed after %d attempts: %w", policy.MaxAttempts, lastErr)
+}
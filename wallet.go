@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// WalletBackend builds the *gateway.Wallet this application authenticates
+// with, populating userName's identity in it if necessary. Selecting a
+// backend (via the -wallet flag) changes where the identity's
+// certificate and private key come from; everything downstream keeps
+// talking to the same *gateway.Wallet/gateway.WithIdentity pair
+// regardless of which backend produced it.
+type WalletBackend interface {
+	Wallet(userName string) (*gateway.Wallet, error)
+}
+
+// FileSystemWalletBackend reads the identity off local disk and persists
+// it to a FileSystemWallet at Path, the same place the original
+// populateWallet wrote to.
+type FileSystemWalletBackend struct {
+	Path     string
+	CredPath string
+}
+
+func (b FileSystemWalletBackend) Wallet(userName string) (*gateway.Wallet, error) {
+	wallet, err := gateway.NewFileSystemWallet(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	if wallet.Exists(userName) {
+		return wallet, nil
+	}
+	cert, key, err := readLocalCredentials(b.CredPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := wallet.Put(userName, gateway.NewX509Identity(mspID, string(cert), string(key))); err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// InMemoryWalletBackend reads the same on-disk credentials as
+// FileSystemWalletBackend, but keeps the resulting identity in memory
+// only: nothing is ever written to a wallet directory, and the identity
+// must be re-populated on every run.
+type InMemoryWalletBackend struct {
+	CredPath string
+}
+
+func (b InMemoryWalletBackend) Wallet(userName string) (*gateway.Wallet, error) {
+	cert, key, err := readLocalCredentials(b.CredPath)
+	if err != nil {
+		return nil, err
+	}
+	wallet := gateway.NewInMemoryWallet()
+	if err := wallet.Put(userName, gateway.NewX509Identity(mspID, string(cert), string(key))); err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// RemoteKeyFetchWalletBackend fetches identity material from an external
+// signer over JSON-RPC instead of reading a keystore file off local disk.
+//
+// Despite the "remote" name, this is NOT remote signing: the private key
+// still ends up held in process memory for the life of the wallet, the
+// same as FileSystemWalletBackend/InMemoryWalletBackend, just fetched from
+// a central signer instead of read off a keystore file on every app host.
+// The version of fabric-sdk-go/pkg/gateway vendored here only accepts raw
+// key material via gateway.NewX509Identity - its public API has no
+// pluggable signer/CryptoSuite hook - so true remote signing (the key
+// never leaving an HSM/signer) is not achievable against this SDK version.
+// Keeping the key fully off the application host would require vendoring
+// a custom BCCSP/CryptoSuite into fabric-sdk-go, which is out of scope for
+// this wallet abstraction. Wallet logs a warning every time this backend
+// is selected so operators don't mistake it for real remote signing.
+type RemoteKeyFetchWalletBackend struct {
+	SignerURL string
+	MspID     string
+	Client    *http.Client
+}
+
+type remoteIdentityRequest struct {
+	JSONRPC string   `json:"jsonrpc"`
+	Method  string   `json:"method"`
+	Params  []string `json:"params"`
+	ID      int      `json:"id"`
+}
+
+type remoteIdentityResult struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"privateKey"`
+}
+
+type remoteIdentityResponse struct {
+	Result *remoteIdentityResult `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	ID int `json:"id"`
+}
+
+func (b RemoteKeyFetchWalletBackend) Wallet(userName string) (*gateway.Wallet, error) {
+	logger.Warn().Str("signerURL", b.SignerURL).Msg("wallet=remote-keyfetch fetches key material over JSON-RPC but still holds the private key in this process's memory for the life of the wallet - it is not remote signing and does not keep keys off this host")
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	reqBody, err := json.Marshal(remoteIdentityRequest{
+		JSONRPC: "2.0",
+		Method:  "signer_getIdentity",
+		Params:  []string{userName},
+		ID:      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(b.SignerURL, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach remote signer at %s: %w", b.SignerURL, err)
+	}
+	defer resp.Body.Close()
+
+	var rpcResp remoteIdentityResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return nil, fmt.Errorf("failed to decode remote signer response: %w", err)
+	}
+	if rpcResp.Error != nil {
+		return nil, fmt.Errorf("remote signer error: %s", rpcResp.Error.Message)
+	}
+	if rpcResp.Result == nil {
+		return nil, fmt.Errorf("remote signer returned no identity for %q", userName)
+	}
+
+	wallet := gateway.NewInMemoryWallet()
+	identity := gateway.NewX509Identity(b.MspID, rpcResp.Result.Certificate, rpcResp.Result.PrivateKey)
+	if err := wallet.Put(userName, identity); err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// readLocalCredentials reads the User1 certificate and keystore file
+// shared by the FileSystem and InMemory wallet backends.
+func readLocalCredentials(credPath string) (cert []byte, key []byte, err error) {
+	certPath := filepath.Join(credPath, "signcerts", "User1@org2.example.com-cert.pem")
+	cert, err = ioutil.ReadFile(filepath.Clean(certPath))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDir := filepath.Join(credPath, "keystore")
+	// there's a single file in this dir containing the private key
+	files, err := ioutil.ReadDir(keyDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) != 1 {
+		return nil, nil, fmt.Errorf("keystore folder should have contain one file")
+	}
+	key, err = ioutil.ReadFile(filepath.Clean(filepath.Join(keyDir, files[0].Name())))
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}